@@ -0,0 +1,200 @@
+package ingester
+
+import (
+	"flag"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// AggregatesConfig configures the rolling per-tenant/per-metric
+// bytes/samples counters served as synthetic series, following Loki's
+// pattern-ingester approach of maintaining small time-bucketed rings
+// alongside the regular chunk path.
+type AggregatesConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	BucketSize time.Duration `yaml:"bucket_size"`
+	Retention  time.Duration `yaml:"retention"`
+}
+
+// RegisterFlags registers flags for AggregatesConfig.
+func (cfg *AggregatesConfig) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "ingester.aggregates-enabled", false, "Enable serving pre-aggregated bytes/samples counters as synthetic series.")
+	f.DurationVar(&cfg.BucketSize, "ingester.aggregates-bucket-size", time.Minute, "Width of each rolling aggregate bucket.")
+	f.DurationVar(&cfg.Retention, "ingester.aggregates-retention", time.Hour, "How long to retain aggregate buckets for.")
+}
+
+const (
+	samplesIngestedMetricName = "cortex_ingester_samples_ingested_total"
+	bytesIngestedMetricName   = "cortex_ingester_bytes_ingested_total"
+)
+
+// aggregateBucket holds the samples/bytes counted within one BucketSize
+// window.
+type aggregateBucket struct {
+	start   model.Time
+	samples uint64
+	bytes   uint64
+}
+
+// aggregateRing is a small ring of time-bucketed counters for one
+// (tenant, metric name) pair, analogous to Loki's per-stream
+// bytes_over_time/count_over_time ring.
+type aggregateRing struct {
+	mtx        sync.Mutex
+	bucketSize time.Duration
+	retention  time.Duration
+	buckets    []aggregateBucket
+}
+
+func newAggregateRing(cfg AggregatesConfig) *aggregateRing {
+	return &aggregateRing{
+		bucketSize: cfg.BucketSize,
+		retention:  cfg.Retention,
+	}
+}
+
+// observe records one sample of sampleBytes bytes at t, rolling the ring
+// forward and evicting buckets older than the configured retention.
+func (r *aggregateRing) observe(t model.Time, sampleBytes uint64) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	bucketMs := r.bucketSize.Milliseconds()
+	if bucketMs <= 0 {
+		// A zero-value AggregatesConfig.BucketSize (Enabled left on without
+		// setting a size) would otherwise divide by zero below; treat it as
+		// one-millisecond buckets.
+		bucketMs = 1
+	}
+
+	bucketStart := t - model.Time(int64(t)%bucketMs)
+	if len(r.buckets) == 0 || r.buckets[len(r.buckets)-1].start != bucketStart {
+		r.buckets = append(r.buckets, aggregateBucket{start: bucketStart})
+	}
+	last := &r.buckets[len(r.buckets)-1]
+	last.samples++
+	last.bytes += sampleBytes
+
+	r.evictOlderThan(t)
+}
+
+// evictOlderThan drops buckets whose start time is older than the
+// configured retention relative to now, bounding the ring's memory
+// footprint regardless of ingest rate.
+func (r *aggregateRing) evictOlderThan(now model.Time) {
+	cutoff := now - model.Time(r.retention.Milliseconds())
+	i := 0
+	for i < len(r.buckets) && r.buckets[i].start < cutoff {
+		i++
+	}
+	r.buckets = r.buckets[i:]
+}
+
+// rate returns (total samples, total bytes, covered duration) across
+// buckets whose start falls within [from, through], so a PromQL rate() over
+// the last N minutes can be answered directly without touching per-series
+// chunks.
+func (r *aggregateRing) rate(from, through model.Time) (samples, bytes uint64, covered time.Duration) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	for _, b := range r.buckets {
+		if b.start < from || b.start > through {
+			continue
+		}
+		samples += b.samples
+		bytes += b.bytes
+		covered += r.bucketSize
+	}
+	return
+}
+
+// aggregator maintains per-tenant, per-metric-name aggregateRings and serves
+// them back as synthetic series under the reserved
+// cortex_ingester_*_ingested_total namespace.
+type aggregator struct {
+	cfg AggregatesConfig
+
+	mtx   sync.RWMutex
+	rings map[string]map[string]*aggregateRing // userID -> metric name -> ring
+}
+
+func newAggregator(cfg AggregatesConfig) *aggregator {
+	return &aggregator{
+		cfg:   cfg,
+		rings: map[string]map[string]*aggregateRing{},
+	}
+}
+
+// observe is called from append() under the series' existing lock. On top of
+// that lock it takes the aggregator's own map lock to find the ring and the
+// ring's mutex to increment the bucket.
+func (a *aggregator) observe(userID, metricName string, t model.Time, sampleBytes uint64) {
+	if !a.cfg.Enabled {
+		return
+	}
+
+	a.mtx.RLock()
+	byMetric, ok := a.rings[userID]
+	a.mtx.RUnlock()
+	if !ok {
+		a.mtx.Lock()
+		byMetric, ok = a.rings[userID]
+		if !ok {
+			byMetric = map[string]*aggregateRing{}
+			a.rings[userID] = byMetric
+		}
+		a.mtx.Unlock()
+	}
+
+	a.mtx.RLock()
+	ring, ok := byMetric[metricName]
+	a.mtx.RUnlock()
+	if !ok {
+		a.mtx.Lock()
+		ring, ok = byMetric[metricName]
+		if !ok {
+			ring = newAggregateRing(a.cfg)
+			byMetric[metricName] = ring
+		}
+		a.mtx.Unlock()
+	}
+
+	ring.observe(t, sampleBytes)
+}
+
+// querySynthetic returns the synthetic samples/bytes series for userID
+// within [from, through], two model.SampleStreams (samples and bytes) per
+// underlying metric name, for QueryStream to fold into its regular response.
+func (a *aggregator) querySynthetic(userID string, from, through model.Time) []*model.SampleStream {
+	if !a.cfg.Enabled {
+		return nil
+	}
+
+	a.mtx.RLock()
+	byMetric := a.rings[userID]
+	a.mtx.RUnlock()
+
+	var out []*model.SampleStream
+	for metricName, ring := range byMetric {
+		samples, bytes, _ := ring.rate(from, through)
+
+		out = append(out,
+			syntheticStream(samplesIngestedMetricName, metricName, through, float64(samples)),
+			syntheticStream(bytesIngestedMetricName, metricName, through, float64(bytes)),
+		)
+	}
+	return out
+}
+
+func syntheticStream(reservedName, metricName string, t model.Time, v float64) *model.SampleStream {
+	return &model.SampleStream{
+		Metric: model.Metric{
+			model.MetricNameLabel: model.LabelValue(reservedName),
+			"metric_name":         model.LabelValue(metricName),
+		},
+		Values: []model.SamplePair{{Timestamp: t, Value: model.SampleValue(v)}},
+	}
+}
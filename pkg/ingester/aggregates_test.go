@@ -0,0 +1,106 @@
+package ingester
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/cortexproject/cortex/pkg/util/chunkcompat"
+)
+
+func TestAggregateRingTracksSamplesIngested(t *testing.T) {
+	cfg := AggregatesConfig{Enabled: true, BucketSize: time.Second, Retention: time.Minute}
+	a := newAggregator(cfg)
+
+	const userID = "1"
+	now := model.Now()
+	for i := 0; i < 100; i++ {
+		a.observe(userID, "testmetric", now, 16)
+	}
+
+	streams := a.querySynthetic(userID, now-model.Time(cfg.Retention.Milliseconds()), now)
+	require.NotEmpty(t, streams)
+
+	var gotSamples, gotBytes bool
+	for _, s := range streams {
+		switch s.Metric[model.MetricNameLabel] {
+		case samplesIngestedMetricName:
+			require.Equal(t, model.SampleValue(100), s.Values[0].Value)
+			gotSamples = true
+		case bytesIngestedMetricName:
+			require.Equal(t, model.SampleValue(1600), s.Values[0].Value)
+			gotBytes = true
+		}
+	}
+	require.True(t, gotSamples)
+	require.True(t, gotBytes)
+}
+
+// TestIngesterPushUpdatesAggregateSeries pushes a known number of samples
+// through Ingester.Push and asserts the aggregate series QueryStream returns
+// (not a standalone aggregator, as TestAggregateRingTracksSamplesIngested
+// covers) reflects that count - proving the aggregator is actually wired
+// into append/QueryStream rather than only independently correct.
+func TestIngesterPushUpdatesAggregateSeries(t *testing.T) {
+	cfg := defaultIngesterTestConfig()
+	cfg.AggregatesConfig = AggregatesConfig{Enabled: true, BucketSize: time.Minute, Retention: time.Hour}
+
+	_, ing := newTestStore(t, cfg, defaultClientTestConfig(), defaultLimitsTestConfig(), nil)
+
+	const userID = "1"
+	const numSamples = 5
+	ctx := user.InjectOrgID(context.Background(), userID)
+	m := labelPairs{{Name: model.MetricNameLabel, Value: "testmetric"}}
+
+	now := model.Now()
+	for i := 0; i < numSamples; i++ {
+		require.NoError(t, ing.append(ctx, userID, m, int64(now)+int64(i), float64(i), client.API, nil))
+	}
+
+	matcher, err := labels.NewMatcher(labels.MatchEqual, model.MetricNameLabel, samplesIngestedMetricName)
+	require.NoError(t, err)
+	req, err := client.ToQueryRequest(0, model.Latest, []*labels.Matcher{matcher})
+	require.NoError(t, err)
+
+	s := stream{ctx: ctx}
+	require.NoError(t, ing.QueryStream(req, &s))
+
+	res, err := chunkcompat.StreamsToMatrix(0, model.Latest, s.responses)
+	require.NoError(t, err)
+
+	var found bool
+	for _, ss := range res {
+		if ss.Metric[model.MetricNameLabel] != samplesIngestedMetricName {
+			continue
+		}
+		found = true
+		require.Equal(t, model.SampleValue(numSamples), ss.Values[len(ss.Values)-1].Value)
+	}
+	require.True(t, found, "expected QueryStream to include the samples-ingested synthetic series")
+}
+
+func TestAggregateRingEvictsOldBuckets(t *testing.T) {
+	r := newAggregateRing(AggregatesConfig{BucketSize: time.Millisecond, Retention: 10 * time.Millisecond})
+
+	start := model.Now()
+	r.observe(start, 8)
+	r.observe(start+model.Time(50), 8)
+
+	require.Len(t, r.buckets, 1, "the first bucket should have been evicted once it aged past retention")
+}
+
+func BenchmarkAggregatorObserve(b *testing.B) {
+	a := newAggregator(AggregatesConfig{Enabled: true, BucketSize: time.Second, Retention: time.Minute})
+	now := model.Now()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		a.observe("1", "bench_metric", now, 16)
+	}
+}
@@ -0,0 +1,140 @@
+package ingester
+
+import (
+	"context"
+	"sort"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+)
+
+// Push accepts one WriteRequest, appending every float sample and (if
+// present) the native histogram sample of each series through append(), so
+// both sample kinds go through identical validation and WAL logging. Blank
+// label values are dropped before a series is resolved, matching Prometheus'
+// own handling of an empty label value as equivalent to the label being
+// absent.
+func (i *Ingester) Push(ctx context.Context, req *client.WriteRequest) (*client.WriteResponse, error) {
+	userID, err := user.ExtractOrgID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ts := range req.Timeseries {
+		metric := make(labelPairs, 0, len(ts.Labels))
+		for _, l := range ts.Labels {
+			if l.Value == "" {
+				continue
+			}
+			metric = append(metric, l)
+		}
+
+		for _, s := range ts.Samples {
+			if err := i.append(ctx, userID, metric, s.TimestampMs, s.Value, client.API, nil); err != nil {
+				return nil, translateValidationError(userID, err)
+			}
+		}
+		if ts.Histogram != nil {
+			if err := i.append(ctx, userID, metric, ts.Histogram.TimestampMs, 0, client.API, ts.Histogram); err != nil {
+				return nil, translateValidationError(userID, err)
+			}
+		}
+	}
+
+	return &client.WriteResponse{}, nil
+}
+
+// translateValidationError turns a *validationError from the append path
+// into the httpgrpc-coded error Push's callers (the gRPC handler chain)
+// expect to translate straight into a response status, tagging it with the
+// tenant so a multi-tenant log line can attribute it.
+func translateValidationError(userID string, err error) error {
+	verr, ok := err.(*validationError)
+	if !ok {
+		return err
+	}
+	return httpgrpc.Errorf(verr.code, "user=%s: %s", userID, verr.msg)
+}
+
+// append is the single entry point for both float and native-histogram
+// samples: Push calls it once per (series, sample) pair, passing histogram
+// only when the write carried a native histogram for that point. A direct
+// caller (as in the append-path tests) passes histogram as nil for a plain
+// float sample.
+func (i *Ingester) append(ctx context.Context, userID string, metric labelPairs, timestampMs int64, value float64, source client.WriteRequest_SourceEnum, histogram *client.Histogram) error {
+	if histogram != nil {
+		return i.appendHistogram(ctx, userID, metric, timestampMs, *histogram, source)
+	}
+	return i.appendSample(ctx, userID, metric, timestampMs, value, source)
+}
+
+// appendSample is the float-sample counterpart to appendHistogram: same
+// out-of-order/duplicate validation against the series' last timestamp, same
+// WAL logging, against the float chunk head instead of the histogram head.
+func (i *Ingester) appendSample(ctx context.Context, userID string, metric labelPairs, timestampMs int64, value float64, source client.WriteRequest_SourceEnum) error {
+	state, err := i.userStates.getOrCreate(userID)
+	if err != nil {
+		return err
+	}
+
+	fp, series, err := state.getOrCreateSeries(ctx, userID, metric, i.overrides)
+	if err != nil {
+		return err
+	}
+	defer state.fpLocker.Unlock(fp)
+
+	t := model.Time(timestampMs)
+	if err := series.checkNewSample(t); err != nil {
+		return err
+	}
+
+	if err := series.add(t, model.SampleValue(value)); err != nil {
+		return err
+	}
+	series.lastTime = t
+	series.lastSampleValue = model.SampleValue(value)
+
+	if i.wal != nil {
+		i.wal.logSeriesOnce(userID, fp, labelPairsToLabels(metric))
+		_ = i.wal.Log(&Record{
+			UserID:  userID,
+			Samples: []RecordSample{{Fp: fp, Timestamp: timestampMs, Value: value, Source: source}},
+		})
+	}
+
+	if i.aggregates != nil {
+		// 8 bytes timestamp + 8 bytes value, matching the on-the-wire
+		// (timestamp, value) pair size the aggregate counters track.
+		i.aggregates.observe(userID, metricName(metric), t, 16)
+	}
+	return nil
+}
+
+// metricName extracts the __name__ label from metric, returning "" if the
+// series somehow has none (append's validation is expected to reject that
+// before it reaches here; this is just a safe fallback for the aggregates
+// path, which tolerates an empty metric name as its own bucket).
+func metricName(metric labelPairs) string {
+	for _, l := range metric {
+		if l.Name == string(model.MetricNameLabel) {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+// labelPairsToLabels converts append()'s wire-shaped labelPairs into
+// labels.Labels, sorted the way RecordSeries (and every other labels.Labels
+// consumer in this package) expects.
+func labelPairsToLabels(lp labelPairs) labels.Labels {
+	lbls := make(labels.Labels, 0, len(lp))
+	for _, l := range lp {
+		lbls = append(lbls, labels.Label{Name: l.Name, Value: l.Value})
+	}
+	sort.Sort(lbls)
+	return lbls
+}
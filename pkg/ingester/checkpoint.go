@@ -0,0 +1,346 @@
+package ingester
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+// checkpointer periodically snapshots an ingester's in-memory series heads
+// to disk and truncates WAL segments that the snapshot makes redundant. It
+// mirrors the checkpointing half of Prometheus' memorySeriesStorage
+// maintenance loop.
+type checkpointer struct {
+	cfg  WALConfig
+	ing  *Ingester
+	wal  *walWrapper
+	quit chan struct{}
+	done chan struct{}
+}
+
+func newCheckpointer(cfg WALConfig, ing *Ingester, wal *walWrapper) *checkpointer {
+	return &checkpointer{
+		cfg:  cfg,
+		ing:  ing,
+		wal:  wal,
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// loop runs until stop() is called, taking a checkpoint every
+// CheckpointInterval but never more often than MinCheckpointDuration. A
+// non-positive CheckpointInterval (the zero Config value, or a test that
+// wants full control over when checkpoints happen) disables automatic
+// ticking rather than passing it to time.NewTicker, which panics on a
+// non-positive duration; callers that want a checkpoint right away call
+// checkpoint() directly instead.
+func (c *checkpointer) loop() {
+	defer close(c.done)
+
+	if c.cfg.CheckpointInterval <= 0 {
+		<-c.quit
+		return
+	}
+
+	ticker := time.NewTicker(c.cfg.CheckpointInterval)
+	defer ticker.Stop()
+
+	var last time.Time
+	for {
+		select {
+		case <-ticker.C:
+			if time.Since(last) < c.cfg.MinCheckpointDuration {
+				continue
+			}
+			if err := c.checkpoint(); err != nil {
+				level.Error(util.Logger).Log("msg", "checkpoint failed", "err", err)
+				continue
+			}
+			last = time.Now()
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+func (c *checkpointer) stop() {
+	close(c.quit)
+	<-c.done
+}
+
+// checkpoint snapshots the current fpToSeries map and float head samples of
+// every user, taking each series' fpLocker stripe while that series is
+// written, writes it to a new checkpoint directory, and truncates WAL
+// segments it now supersedes. Histogram heads are not part of the snapshot.
+func (c *checkpointer) checkpoint() error {
+	start := time.Now()
+	dir := filepath.Join(c.cfg.Dir, "checkpoint."+start.Format("20060102150405"))
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return errors.Wrap(err, "create checkpoint dir")
+	}
+
+	f, err := os.Create(filepath.Join(dir, "snapshot"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Rotate the WAL onto a fresh segment before scanning any series, so
+	// every append from this point on lands in the new segment rather than
+	// the one this snapshot is about to supersede. That guarantees the old
+	// segment is safe to delete once the snapshot is durable, regardless of
+	// how the (unsynchronized, one-user-at-a-time) scan below interleaves
+	// with concurrent appends.
+	var coveredSegment string
+	if c.wal != nil {
+		coveredSegment, err = c.wal.rotate()
+		if err != nil {
+			return errors.Wrap(err, "rotate WAL segment")
+		}
+	}
+
+	for _, userID := range c.ing.userStates.cp() {
+		state, ok := c.ing.userStates.get(userID)
+		if !ok {
+			continue
+		}
+		err := state.forSeriesMatching(nil, nil, func(fp model.Fingerprint, s *memorySeries) error {
+			return writeCheckpointSeries(f, userID, fp, s)
+		}, nil, nil)
+		if err != nil {
+			return errors.Wrap(err, "write checkpoint series")
+		}
+	}
+
+	if err := swapLatestCheckpoint(c.cfg.Dir, dir); err != nil {
+		return err
+	}
+	if coveredSegment != "" {
+		if err := os.Remove(coveredSegment); err != nil {
+			c.wal.metrics.checkpointDeleteErr.Inc()
+		}
+	}
+	c.wal.metrics.checkpointDuration.Observe(time.Since(start).Seconds())
+	return nil
+}
+
+// swapLatestCheckpoint atomically points a "checkpoint.latest" symlink at
+// dir and removes any older checkpoint directories, so a crash between
+// writing a new checkpoint and deleting the old one always leaves exactly
+// one valid checkpoint to replay.
+func swapLatestCheckpoint(walDir, dir string) error {
+	latest := filepath.Join(walDir, "checkpoint.latest")
+	tmp := latest + ".tmp"
+	_ = os.Remove(tmp)
+	if err := os.Symlink(filepath.Base(dir), tmp); err != nil {
+		return errors.Wrap(err, "symlink checkpoint")
+	}
+	if err := os.Rename(tmp, latest); err != nil {
+		return errors.Wrap(err, "swap checkpoint symlink")
+	}
+
+	entries, err := ioutil.ReadDir(walDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Name() == filepath.Base(dir) || e.Name() == "checkpoint.latest" || !e.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(e.Name(), "checkpoint.") {
+			_ = os.RemoveAll(filepath.Join(walDir, e.Name()))
+		}
+	}
+	return nil
+}
+
+// recoverFromWAL replays the latest checkpoint, if any, followed by any WAL
+// segments newer than it, rebuilding fpToSeries before the ingester starts
+// accepting writes or joins the ring.
+func (ing *Ingester) recoverFromWAL() error {
+	start := time.Now()
+	cfg := ing.cfg.WALConfig
+	if !cfg.WALEnabled && !cfg.Recover {
+		return nil
+	}
+
+	if err := ing.replayCheckpoint(cfg.Dir); err != nil {
+		level.Warn(util.Logger).Log("msg", "no checkpoint to replay", "err", err)
+	}
+	if err := ing.replayWALSegments(cfg.Dir); err != nil {
+		return errors.Wrap(err, "replay WAL segments")
+	}
+
+	level.Info(util.Logger).Log("msg", "WAL replay complete", "duration", time.Since(start))
+	return nil
+}
+
+func (ing *Ingester) replayCheckpoint(walDir string) error {
+	latest, err := os.Readlink(filepath.Join(walDir, "checkpoint.latest"))
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(filepath.Join(walDir, latest, "snapshot"))
+	if err != nil {
+		return err
+	}
+	return readCheckpointSeries(data, ing.applyRecoveredSeries)
+}
+
+// replayWALSegments replays every numbered segment file in walDir, in
+// ascending order. A checkpoint leaves behind the one segment that was
+// current when it rotated (plus any newer ones written since), so after a
+// restart there can be more than just "00000000" waiting to be replayed.
+func (ing *Ingester) replayWALSegments(walDir string) error {
+	entries, err := ioutil.ReadDir(walDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var segments []string
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if _, err := strconv.Atoi(e.Name()); err != nil {
+			continue // not a numbered segment file (e.g. "checkpoint.latest").
+		}
+		segments = append(segments, e.Name())
+	}
+	sort.Strings(segments)
+
+	for _, name := range segments {
+		data, err := ioutil.ReadFile(filepath.Join(walDir, name))
+		if err != nil {
+			return errors.Wrapf(err, "read WAL segment %s", name)
+		}
+		if err := readSegment(data, ing.applyRecoveredRecord); err != nil {
+			return errors.Wrapf(err, "replay WAL segment %s", name)
+		}
+	}
+	return nil
+}
+
+// applyRecoveredRecord replays a single WAL record into the in-memory head,
+// reusing the same append path as live traffic so validation and chunk
+// encoding stay identical between normal writes and replay. Series records
+// are applied first within a record (a record never mixes the two, and a
+// series' registration record always precedes its first sample record in
+// file order), so a sample that lands in the same segment as its series'
+// first-seen record always has somewhere to go even without a checkpoint.
+func (ing *Ingester) applyRecoveredRecord(rec *Record) error {
+	for _, s := range rec.Series {
+		if err := ing.applyRecoveredSeriesRegistration(rec.UserID, s); err != nil {
+			return err
+		}
+	}
+	for _, s := range rec.Samples {
+		if err := ing.appendRecovered(rec.UserID, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyRecoveredSeriesRegistration re-registers a series from a WAL Series
+// record during replay, the WAL-only counterpart to applyRecoveredSeries
+// (which does the same from a checkpoint snapshot).
+func (ing *Ingester) applyRecoveredSeriesRegistration(userID string, rs RecordSeries) error {
+	state, err := ing.userStates.getOrCreate(userID)
+	if err != nil {
+		return errors.Wrap(err, "recover series registration")
+	}
+	fp, _, err := state.getOrCreateSeries(context.Background(), userID, checkpointLabelsToLabelPairs(rs.Labels), nil)
+	if err != nil {
+		return err
+	}
+	state.fpLocker.Unlock(fp)
+	return nil
+}
+
+// applyRecoveredSeries re-registers a single series from a checkpoint
+// snapshot and restores its float head samples and last-seen
+// timestamp/value, so the subsequent WAL
+// replay's out-of-order check has the right starting point to compare
+// against.
+func (ing *Ingester) applyRecoveredSeries(cs checkpointSeries) error {
+	state, err := ing.userStates.getOrCreate(cs.UserID)
+	if err != nil {
+		return errors.Wrap(err, "recover series state")
+	}
+
+	fp, series, err := state.getOrCreateSeries(context.Background(), cs.UserID, checkpointLabelsToLabelPairs(cs.Labels), nil)
+	if err != nil {
+		return errors.Wrap(err, "recover series")
+	}
+
+	defer state.fpLocker.Unlock(fp)
+	if len(cs.HeadChunk) > 0 {
+		samples, err := unmarshalHeadFromCheckpoint(cs.HeadChunk)
+		if err != nil {
+			return errors.Wrap(err, "recover head chunk")
+		}
+		series.samples = samples
+	}
+	series.lastTime = model.Time(cs.LastTime)
+	series.lastSampleValue = model.SampleValue(cs.LastValue)
+	return nil
+}
+
+// appendRecovered replays one WAL sample record into the series recovered
+// from the checkpoint (or registered by an earlier Series record in this
+// same WAL replay), bypassing checkNewSample's out-of-order check since this
+// data was already accepted and WAL-logged before the crash; add() still
+// rejects a repeated timestamp that carries a different value. It
+// re-applies the value to the series' chunk head, not just the
+// lastTime/lastSampleValue bookkeeping fields, so the recovered sample is
+// actually there to be queried afterwards.
+func (ing *Ingester) appendRecovered(userID string, s RecordSample) error {
+	state, ok := ing.userStates.get(userID)
+	if !ok {
+		return errors.Errorf("no recovered series state for user %s", userID)
+	}
+
+	state.fpLocker.Lock(s.Fp)
+	defer state.fpLocker.Unlock(s.Fp)
+
+	series, ok := state.fpToSeries[s.Fp]
+	if !ok {
+		return errors.Errorf("WAL sample for unknown fingerprint %v", s.Fp)
+	}
+
+	t := model.Time(s.Timestamp)
+	if err := series.add(t, model.SampleValue(s.Value)); err != nil {
+		return errors.Wrap(err, "replay sample into chunk head")
+	}
+	series.lastTime = t
+	series.lastSampleValue = model.SampleValue(s.Value)
+	return nil
+}
+
+// checkpointLabelsToLabelPairs converts a checkpoint's plain labels.Labels
+// back into the labelPairs shape append() and getOrCreateSeries expect.
+func checkpointLabelsToLabelPairs(lbls labels.Labels) labelPairs {
+	lp := make(labelPairs, 0, len(lbls))
+	for _, l := range lbls {
+		lp = append(lp, client.LabelAdapter{Name: l.Name, Value: l.Value})
+	}
+	return lp
+}
@@ -0,0 +1,50 @@
+package client
+
+// Histogram is the wire representation of a Prometheus sparse native
+// histogram: an exponential-bucket schema plus delta-encoded span/bucket
+// lists, following the model described in
+// https://prometheus.io/docs/concepts/native_histograms/.
+type Histogram struct {
+	// TimestampMs is the sample timestamp, matching Sample.TimestampMs.
+	TimestampMs int64
+
+	// Schema selects the exponential bucket boundary factor: bucket
+	// boundaries are pow(2, pow(2, -schema)). Schema is in [-4, 8].
+	Schema int32
+
+	// ZeroThreshold is the width of the zero bucket, which absorbs
+	// observations close enough to zero that their float64 bucket index
+	// would be unstable.
+	ZeroThreshold float64
+	ZeroCount     uint64
+
+	// Count and Sum are the total observation count and sum, as with a
+	// classic histogram.
+	Count uint64
+	Sum   float64
+
+	// PositiveSpans/PositiveDeltas and NegativeSpans/NegativeDeltas encode
+	// the sparse bucket counts either side of zero: each Span covers a run
+	// of consecutive bucket indexes, and each delta is relative to the
+	// previous populated bucket's count (the first delta is relative to
+	// zero), so long runs of empty or equal-count buckets cost almost
+	// nothing on the wire.
+	PositiveSpans  []HistogramSpan
+	PositiveDeltas []int64
+	NegativeSpans  []HistogramSpan
+	NegativeDeltas []int64
+}
+
+// HistogramSpan is a run of `Length` consecutive bucket indexes starting
+// `Offset` buckets after the previously emitted span (or after bucket 0 for
+// the first span).
+type HistogramSpan struct {
+	Offset int32
+	Length uint32
+}
+
+// IsEmpty reports whether h carries no observations, used by append() to
+// skip writing a histogram chunk entry for a zero-value placeholder.
+func (h *Histogram) IsEmpty() bool {
+	return h == nil || h.Count == 0
+}
@@ -0,0 +1,35 @@
+package client
+
+import (
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// LabelNamesRequest carries an optional matcher pushdown and time range for
+// Ingester.LabelNames, mirroring QueryRequest's shape so the ingester can
+// reuse the same matcher resolution path for both RPCs.
+type LabelNamesRequest struct {
+	Matchers         []*LabelMatcher
+	StartTimestampMs int64
+	EndTimestampMs   int64
+}
+
+// LabelNamesResponse is the sorted, deduplicated set of label names that
+// matched a LabelNamesRequest.
+type LabelNamesResponse struct {
+	LabelNames []string
+}
+
+// ToLabelNamesRequest builds a LabelNamesRequest from a set of matchers and a
+// time range, following the same conversion pattern as ToQueryRequest.
+func ToLabelNamesRequest(from, through model.Time, matchers []*labels.Matcher) (*LabelNamesRequest, error) {
+	ms, err := ToLabelMatchers(matchers)
+	if err != nil {
+		return nil, err
+	}
+	return &LabelNamesRequest{
+		Matchers:         ms,
+		StartTimestampMs: int64(from),
+		EndTimestampMs:   int64(through),
+	}, nil
+}
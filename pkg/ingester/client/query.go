@@ -0,0 +1,187 @@
+package client
+
+import (
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// LabelMatcher is the wire form of a labels.Matcher, carrying its type as an
+// integer so a decoder doesn't need to depend on the labels package's own
+// matcher type ordering.
+type LabelMatcher struct {
+	Type  int32
+	Name  string
+	Value string
+}
+
+const (
+	matchEqual int32 = iota
+	matchNotEqual
+	matchRegexp
+	matchNotRegexp
+)
+
+// ToLabelMatchers converts PromQL matchers into their wire form.
+func ToLabelMatchers(matchers []*labels.Matcher) ([]*LabelMatcher, error) {
+	out := make([]*LabelMatcher, 0, len(matchers))
+	for _, m := range matchers {
+		var t int32
+		switch m.Type {
+		case labels.MatchEqual:
+			t = matchEqual
+		case labels.MatchNotEqual:
+			t = matchNotEqual
+		case labels.MatchRegexp:
+			t = matchRegexp
+		case labels.MatchNotRegexp:
+			t = matchNotRegexp
+		default:
+			return nil, errUnknownMatchType
+		}
+		out = append(out, &LabelMatcher{Type: t, Name: m.Name, Value: m.Value})
+	}
+	return out, nil
+}
+
+// FromLabelMatchers is the inverse of ToLabelMatchers.
+func FromLabelMatchers(matchers []*LabelMatcher) ([]*labels.Matcher, error) {
+	out := make([]*labels.Matcher, 0, len(matchers))
+	for _, m := range matchers {
+		var t labels.MatchType
+		switch m.Type {
+		case matchEqual:
+			t = labels.MatchEqual
+		case matchNotEqual:
+			t = labels.MatchNotEqual
+		case matchRegexp:
+			t = labels.MatchRegexp
+		case matchNotRegexp:
+			t = labels.MatchNotRegexp
+		default:
+			return nil, errUnknownMatchType
+		}
+		matcher, err := labels.NewMatcher(t, m.Name, m.Value)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, matcher)
+	}
+	return out, nil
+}
+
+var errUnknownMatchType = errUnknown("unknown label matcher type")
+
+type errUnknown string
+
+func (e errUnknown) Error() string { return string(e) }
+
+// QueryRequest is the Query/QueryStream RPC's request payload: a time range
+// plus the matchers selecting which series it covers.
+type QueryRequest struct {
+	StartTimestampMs int64
+	EndTimestampMs   int64
+	Matchers         []*LabelMatcher
+}
+
+// ToQueryRequest builds a QueryRequest from a time range and PromQL
+// matchers, the same conversion ToLabelNamesRequest already does for
+// LabelNamesRequest.
+func ToQueryRequest(from, through model.Time, matchers []*labels.Matcher) (*QueryRequest, error) {
+	ms, err := ToLabelMatchers(matchers)
+	if err != nil {
+		return nil, err
+	}
+	return &QueryRequest{
+		StartTimestampMs: int64(from),
+		EndTimestampMs:   int64(through),
+		Matchers:         ms,
+	}, nil
+}
+
+// QueryResponse carries every matching series' labels and float samples in
+// full, for the plain (non-streaming) Query RPC.
+type QueryResponse struct {
+	Timeseries []*TimeSeries
+}
+
+// FromQueryResponse converts a QueryResponse into a model.Matrix, the shape
+// ingester_test.go's helpers compare query results against.
+func FromQueryResponse(resp *QueryResponse) model.Matrix {
+	m := make(model.Matrix, 0, len(resp.Timeseries))
+	for _, ts := range resp.Timeseries {
+		values := make([]model.SamplePair, 0, len(ts.Samples))
+		for _, s := range ts.Samples {
+			values = append(values, model.SamplePair{
+				Timestamp: model.Time(s.TimestampMs),
+				Value:     model.SampleValue(s.Value),
+			})
+		}
+		m = append(m, &model.SampleStream{
+			Metric: FromLabelAdaptersToMetric(ts.Labels),
+			Values: values,
+		})
+	}
+	return m
+}
+
+// QueryStreamResponse carries one batch of matching series as encoded
+// chunks, for the streaming QueryStream RPC.
+type QueryStreamResponse struct {
+	Timeseries []*TimeSeriesChunk
+}
+
+// FromLabelAdaptersToLabels converts the wire label form to labels.Labels.
+func FromLabelAdaptersToLabels(ls []LabelAdapter) labels.Labels {
+	out := make(labels.Labels, 0, len(ls))
+	for _, l := range ls {
+		out = append(out, labels.Label{Name: l.Name, Value: l.Value})
+	}
+	return out
+}
+
+// FromLabelsToLabelAdapters converts labels.Labels to the wire label form.
+func FromLabelsToLabelAdapters(ls labels.Labels) []LabelAdapter {
+	out := make([]LabelAdapter, 0, len(ls))
+	for _, l := range ls {
+		out = append(out, LabelAdapter{Name: l.Name, Value: l.Value})
+	}
+	return out
+}
+
+// FromLabelAdaptersToMetric converts the wire label form to a model.Metric.
+func FromLabelAdaptersToMetric(ls []LabelAdapter) model.Metric {
+	metric := make(model.Metric, len(ls))
+	for _, l := range ls {
+		metric[model.LabelName(l.Name)] = model.LabelValue(l.Value)
+	}
+	return metric
+}
+
+// FromMetricsToLabelAdapters converts a model.Metric to the wire label form.
+func FromMetricsToLabelAdapters(metric model.Metric) []LabelAdapter {
+	out := make([]LabelAdapter, 0, len(metric))
+	for name, value := range metric {
+		out = append(out, LabelAdapter{Name: string(name), Value: string(value)})
+	}
+	return out
+}
+
+// ToWriteRequest builds a WriteRequest out of one label set and one sample
+// per series, pairing lbls[i] with samples[i] - the shape every test helper
+// in this package already produces. histograms, if non-nil, must be the same
+// length as lbls/samples; a nil entry means that series carries no native
+// histogram sample in this request.
+func ToWriteRequest(lbls []labels.Labels, samples []Sample, histograms []*Histogram, source WriteRequest_SourceEnum) *WriteRequest {
+	ts := make([]PreallocTimeseries, 0, len(lbls))
+	for i, l := range lbls {
+		entry := &TimeSeries{
+			Labels:  FromLabelsToLabelAdapters(l),
+			Samples: []Sample{samples[i]},
+		}
+		if histograms != nil && histograms[i] != nil {
+			entry.Histogram = histograms[i]
+		}
+		ts = append(ts, PreallocTimeseries{TimeSeries: entry})
+	}
+	return &WriteRequest{Timeseries: ts}
+}
@@ -0,0 +1,92 @@
+package client
+
+import "flag"
+
+// Config holds the ingester client's own settings - the gRPC connection
+// this ingester is reached over, as opposed to Ingester's Config, which
+// holds the ingester's own behaviour. Nothing in this series reads these
+// fields yet; they exist so callers that construct a client alongside an
+// Ingester (as every test in this package does) have a real type to pass.
+type Config struct {
+	MaxRecvMsgSize int `yaml:"max_recv_msg_size"`
+}
+
+// RegisterFlags registers flags for Config.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	f.IntVar(&cfg.MaxRecvMsgSize, "ingester.client.max-recv-msg-size", 64*1024*1024, "Maximum gRPC message size the ingester client will accept.")
+}
+
+// LabelAdapter is the wire form of a single label.
+type LabelAdapter struct {
+	Name  string
+	Value string
+}
+
+// Sample is a single (timestamp, value) float point.
+type Sample struct {
+	TimestampMs int64
+	Value       float64
+}
+
+// TimeSeries is one series' labels plus the samples/histogram a Push call is
+// appending for it. One entry can carry float samples and a native
+// histogram point together, but at most one histogram point; a client
+// sending several histogram points for the same series in one request splits
+// them across several WriteRequest entries rather than this carrying a slice
+// of histograms.
+type TimeSeries struct {
+	Labels    []LabelAdapter
+	Samples   []Sample
+	Histogram *Histogram
+}
+
+// WriteRequest_SourceEnum records whether a write came from the normal API
+// path or from a recording/alerting rule.
+type WriteRequest_SourceEnum int
+
+const (
+	API WriteRequest_SourceEnum = iota
+	RULE
+)
+
+// PreallocTimeseries wraps a *TimeSeries so a WriteRequest's slice can be
+// preallocated and reused across decodes without copying TimeSeries itself.
+type PreallocTimeseries struct {
+	*TimeSeries
+}
+
+// WriteRequest is the Push RPC's request payload.
+type WriteRequest struct {
+	Timeseries []PreallocTimeseries
+}
+
+// WriteResponse is the (empty) Push RPC response.
+type WriteResponse struct{}
+
+// Chunk is the wire representation of one encoded chunk: its byte payload
+// plus which encoding produced it, so the receiving side can decode it
+// without a side channel.
+type Chunk struct {
+	Encoding int32
+	Data     []byte
+}
+
+// GobFloatEncoding and GobHistogramEncoding tag a Chunk's Data as,
+// respectively, a gob-encoded []model.SamplePair or the raw bytes of a
+// HistogramChunk as written by its Marshal (see pkg/chunk/encoding and
+// pkg/util/chunkcompat) - this
+// package's own simple chunk framing, distinct from the DoubleDelta/
+// Varbit/Bigchunk encodings a production chunk store uses.
+const (
+	GobFloatEncoding int32 = iota
+	GobHistogramEncoding
+)
+
+// TimeSeriesChunk is one series' encoded chunks as returned by QueryStream:
+// float samples in Chunks, native histogram samples in HistogramChunks.
+type TimeSeriesChunk struct {
+	UserID          string
+	Labels          []LabelAdapter
+	Chunks          []Chunk
+	HistogramChunks []Chunk
+}
@@ -0,0 +1,228 @@
+package ingester
+
+import (
+	"context"
+	"flag"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+// CuratorConfig configures the background deletion pass that enforces
+// per-tenant retention inside the ingester, modeled on Prometheus' tiered
+// storage DeletionProcessor/Curator.
+type CuratorConfig struct {
+	Enabled                  bool          `yaml:"enabled"`
+	Period                   time.Duration `yaml:"period"`
+	MaximumMutationPoolBatch int           `yaml:"max_mutation_batch"`
+}
+
+// RegisterFlags registers flags for CuratorConfig.
+func (cfg *CuratorConfig) RegisterFlags(f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, "ingester.curator-enabled", false, "Enable periodic deletion of samples past their tenant's retention period.")
+	f.DurationVar(&cfg.Period, "ingester.curator-period", 1*time.Hour, "How often to run a curation pass.")
+	f.IntVar(&cfg.MaximumMutationPoolBatch, "ingester.curator-max-mutation-batch", 1000, "Number of series curated between the short pauses of a curation pass.")
+}
+
+// curatorRemark records how far a tenant's curation pass got: lastFingerprint
+// is the last series fp a pass finished curating, and complete is false if
+// that pass was aborted (ingester shutdown) partway through. The next pass
+// skips straight to lastFingerprint when the previous one didn't finish,
+// rather than rescanning series it already curated.
+type curatorRemark struct {
+	lastCuratedAt   time.Time
+	lastFingerprint model.Fingerprint
+	complete        bool
+}
+
+// curator walks every tenant's series deleting samples older than that
+// tenant's RetentionPeriod, in MaximumMutationPoolBatch-sized batches with a
+// short pause between batches; each series' lock is held while that series
+// is curated and, at a batch boundary, through the pause. Only
+// run()'s own ticker loop ever calls runPass, and it does so synchronously -
+// one pass always finishes (or is aborted by stop()) before the next ticker
+// tick is read - so no two passes can ever run concurrently without an
+// explicit semaphore.
+type curator struct {
+	cfg       CuratorConfig
+	overrides *validation.Overrides
+	states    *userStates
+
+	quit chan struct{}
+	done chan struct{}
+
+	remarksMtx sync.Mutex
+	remarks    map[string]curatorRemark
+
+	metrics *curatorMetrics
+}
+
+type curatorMetrics struct {
+	scanned  prometheus.Counter
+	deleted  prometheus.Counter
+	duration prometheus.Histogram
+}
+
+func newCuratorMetrics(reg prometheus.Registerer) *curatorMetrics {
+	m := &curatorMetrics{
+		scanned: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "cortex_ingester_curator_samples_scanned_total",
+			Help: "Total number of samples scanned by the curator.",
+		}),
+		deleted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "cortex_ingester_curator_samples_deleted_total",
+			Help: "Total number of samples deleted by the curator for exceeding tenant retention.",
+		}),
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "cortex_ingester_curator_pass_duration_seconds",
+			Help:    "Time taken for a single curation pass across all tenants.",
+			Buckets: prometheus.DefBuckets,
+		}),
+	}
+	if reg != nil {
+		reg.MustRegister(m.scanned, m.deleted, m.duration)
+	}
+	return m
+}
+
+// newCurator creates a curator that has not yet started its background
+// loop; call run() to start it.
+func newCurator(cfg CuratorConfig, overrides *validation.Overrides, states *userStates, reg prometheus.Registerer) *curator {
+	return &curator{
+		cfg:       cfg,
+		overrides: overrides,
+		states:    states,
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+		remarks:   map[string]curatorRemark{},
+		metrics:   newCuratorMetrics(reg),
+	}
+}
+
+// run drives curation passes every cfg.Period until stop() is called.
+func (c *curator) run() {
+	defer close(c.done)
+	if !c.cfg.Enabled {
+		return
+	}
+
+	ticker := time.NewTicker(c.cfg.Period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.runPass()
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// stop aborts any in-flight pass as soon as it next checks the quit channel
+// and waits for the background loop to exit.
+func (c *curator) stop() {
+	close(c.quit)
+	<-c.done
+}
+
+// runPass curates every known tenant in turn.
+func (c *curator) runPass() {
+	start := time.Now()
+	for _, userID := range c.states.cp() {
+		select {
+		case <-c.quit:
+			return
+		default:
+		}
+		c.curateUser(userID)
+	}
+	c.metrics.duration.Observe(time.Since(start).Seconds())
+}
+
+// curateUser deletes samples older than the tenant's RetentionPeriod,
+// resuming from the tenant's last curation remark rather than rescanning
+// from the beginning of its retained data.
+func (c *curator) curateUser(userID string) {
+	state, ok := c.states.get(userID)
+	if !ok {
+		return
+	}
+
+	retention := c.overrides.RetentionPeriod(userID)
+	if retention <= 0 {
+		return
+	}
+	cutoff := model.TimeFromUnixNano(time.Now().Add(-retention).UnixNano())
+
+	c.remarksMtx.Lock()
+	remark := c.remarks[userID]
+	c.remarksMtx.Unlock()
+
+	var resumeFrom model.Fingerprint
+	if !remark.complete {
+		resumeFrom = remark.lastFingerprint
+	}
+
+	deleted := 0
+	batch := 0
+	aborted := false
+	lastFP := resumeFrom
+	err := state.forSeriesMatching(context.Background(), nil, func(fp model.Fingerprint, s *memorySeries) error {
+		if resumeFrom != 0 && fp <= resumeFrom {
+			return nil
+		}
+
+		select {
+		case <-c.quit:
+			aborted = true
+			return errCuratorAborted
+		default:
+		}
+
+		n := s.dropSamplesBefore(cutoff)
+		deleted += n
+		lastFP = fp
+		c.metrics.scanned.Inc()
+		c.metrics.deleted.Add(float64(n))
+
+		batch++
+		if batch >= c.cfg.MaximumMutationPoolBatch {
+			batch = 0
+			// Pause briefly between batches so a long pass yields the CPU.
+			// The pause runs inside fn, so the lock stripe of the series
+			// just curated stays held while it waits.
+			select {
+			case <-time.After(time.Millisecond):
+			case <-c.quit:
+				aborted = true
+				return errCuratorAborted
+			}
+		}
+		return nil
+	}, nil, nil)
+	if err != nil && err != errCuratorAborted {
+		level.Error(util.Logger).Log("msg", "curation pass failed", "user", userID, "err", err)
+		return
+	}
+	level.Debug(util.Logger).Log("msg", "curation pass complete", "user", userID, "deleted", deleted, "aborted", aborted)
+
+	c.remarksMtx.Lock()
+	if aborted {
+		c.remarks[userID] = curatorRemark{lastCuratedAt: remark.lastCuratedAt, lastFingerprint: lastFP, complete: false}
+	} else {
+		c.remarks[userID] = curatorRemark{lastCuratedAt: time.Now(), complete: true}
+	}
+	c.remarksMtx.Unlock()
+}
+
+var errCuratorAborted = &curatorAbortedErr{}
+
+type curatorAbortedErr struct{}
+
+func (*curatorAbortedErr) Error() string { return "curation pass aborted on shutdown" }
@@ -0,0 +1,21 @@
+package ingester
+
+import "fmt"
+
+// validationError is returned by the append path when a sample fails
+// per-series validation (out-of-order, duplicate-with-different-value) or a
+// tenant's series limits are exceeded. Its code mirrors the HTTP status the
+// gRPC gateway should translate the failure to, so callers can type-assert
+// it rather than string-matching Error().
+type validationError struct {
+	msg  string
+	code int
+}
+
+func (e *validationError) Error() string {
+	return e.msg
+}
+
+func newValidationError(code int, format string, args ...interface{}) *validationError {
+	return &validationError{msg: fmt.Sprintf(format, args...), code: code}
+}
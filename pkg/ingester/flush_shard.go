@@ -0,0 +1,164 @@
+package ingester
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+// flushShard owns one bounded channel of flushItems and its own batching and
+// backoff state, so a slow or erroring store only backpressures the series
+// hashed onto this shard rather than every series in the ingester.
+type flushShard struct {
+	id      int
+	cfg     QueueManagerConfig
+	store   chunkStore
+	metrics *queueManagerMetrics
+
+	queue chan flushItem
+	quit  chan struct{}
+	done  chan struct{}
+
+	in, out atomicCounter
+}
+
+type atomicCounter struct {
+	v int64
+}
+
+func (c *atomicCounter) add(n int64)  { atomic.AddInt64(&c.v, n) }
+func (c *atomicCounter) reset() int64 { return atomic.SwapInt64(&c.v, 0) }
+
+func newFlushShard(id int, cfg QueueManagerConfig, store chunkStore, metrics *queueManagerMetrics) *flushShard {
+	s := &flushShard{
+		id:      id,
+		cfg:     cfg,
+		store:   store,
+		metrics: metrics,
+		queue:   make(chan flushItem, cfg.Capacity),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// inRate and outRate report chunks enqueued/flushed since the last call,
+// used by queueManager.reshard to estimate the shard count needed to keep
+// the in-rate and out-rate in balance.
+func (s *flushShard) inRate() float64  { return float64(s.in.reset()) }
+func (s *flushShard) outRate() float64 { return float64(s.out.reset()) }
+
+// run batches queued items by MaxSamplesPerSend or BatchSendDeadline,
+// whichever comes first, and retries store.Put with exponential backoff on
+// error so a transient store outage doesn't drop data - it just grows this
+// shard's queue, which is what drives the scale-up decision in reshard().
+func (s *flushShard) run() {
+	defer close(s.done)
+
+	label := strconv.Itoa(s.id)
+	var batch []flushItem
+	timer := time.NewTimer(s.cfg.BatchSendDeadline)
+	defer timer.Stop()
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		s.sendWithBackoff(label, batch)
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case item, ok := <-s.queue:
+			if !ok {
+				flush()
+				return
+			}
+			s.in.add(1)
+			s.metrics.queueDepth.WithLabelValues(label).Set(float64(len(s.queue)))
+			batch = append(batch, item)
+			if len(batch) >= s.cfg.MaxSamplesPerSend {
+				flush()
+				timer.Reset(s.cfg.BatchSendDeadline)
+			}
+		case <-timer.C:
+			flush()
+			timer.Reset(s.cfg.BatchSendDeadline)
+		case <-s.quit:
+			// Drain whatever's still buffered in the channel before
+			// exiting: a reshard or shutdown closes quit while the channel
+			// can still hold items that were enqueued but never read by
+			// this loop, and sendBatch talks straight to the store
+			// regardless of which shard processed the item, so draining
+			// here is enough to avoid losing them.
+			for drained := false; !drained; {
+				select {
+				case item, ok := <-s.queue:
+					if !ok {
+						drained = true
+						break
+					}
+					batch = append(batch, item)
+					if len(batch) >= s.cfg.MaxSamplesPerSend {
+						flush()
+					}
+				default:
+					drained = true
+				}
+			}
+			flush()
+			return
+		}
+	}
+}
+
+// sendWithBackoff retries the whole batch against the store with
+// exponentially increasing backoff, giving up only when the shard is being
+// stopped - in which case the batch is counted as dropped rather than
+// retried forever.
+func (s *flushShard) sendWithBackoff(label string, batch []flushItem) {
+	backoff := s.cfg.MinBackoff
+	for {
+		err := s.sendBatch(batch)
+		if err == nil {
+			s.out.add(int64(len(batch)))
+			s.metrics.samplesOut.WithLabelValues(label).Add(float64(len(batch)))
+			return
+		}
+		s.metrics.retries.WithLabelValues(label).Inc()
+		level.Warn(util.Logger).Log("msg", "flush shard retrying after store error", "shard", s.id, "err", err)
+
+		select {
+		case <-time.After(backoff):
+		case <-s.quit:
+			s.metrics.samplesDropped.WithLabelValues(label).Add(float64(len(batch)))
+			return
+		}
+		backoff *= 2
+		if backoff > s.cfg.MaxBackoff {
+			backoff = s.cfg.MaxBackoff
+		}
+	}
+}
+
+func (s *flushShard) sendBatch(batch []flushItem) error {
+	ctx := context.Background()
+	for _, item := range batch {
+		if err := s.store.Put(ctx, item.chunks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *flushShard) stop() {
+	close(s.quit)
+	<-s.done
+}
@@ -0,0 +1,75 @@
+package ingester
+
+import (
+	"context"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/cortexproject/cortex/pkg/chunk/encoding"
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+)
+
+// appendHistogram is the histogram counterpart to append(): it runs the same
+// checkNewSample validation as the float path, then hands the sample to the
+// series' histogram head chunk, creating one if this is the series' first
+// histogram observation. The head chunk rejects a timestamp at or before its
+// last histogram sample, which is reported as an out-of-order validation
+// error.
+func (i *Ingester) appendHistogram(ctx context.Context, userID string, metric labelPairs, timestampMs int64, h client.Histogram, source client.WriteRequest_SourceEnum) error {
+	if h.IsEmpty() {
+		return nil
+	}
+
+	state, err := i.userStates.getOrCreate(userID)
+	if err != nil {
+		return err
+	}
+
+	fp, series, err := state.getOrCreateSeries(ctx, userID, metric, i.overrides)
+	if err != nil {
+		return err
+	}
+	defer state.fpLocker.Unlock(fp)
+
+	t := model.Time(timestampMs)
+	if err := series.checkNewSample(t); err != nil {
+		return err
+	}
+
+	if series.histogramHead == nil {
+		series.histogramHead = encoding.NewHistogramChunk()
+	}
+	if _, err := series.histogramHead.Add(t, &h); err != nil {
+		if err == encoding.ErrOutOfBounds {
+			return newValidationError(400, "sample timestamp out of order; incoming timestamp: %s for series %s", t, series.metric)
+		}
+		return err
+	}
+
+	if i.wal != nil {
+		i.wal.logSeriesOnce(userID, fp, labelPairsToLabels(metric))
+		_ = i.wal.Log(&Record{
+			UserID: userID,
+			Samples: []RecordSample{{
+				Fp:        fp,
+				Timestamp: timestampMs,
+				Source:    source,
+			}},
+		})
+	}
+
+	if i.aggregates != nil {
+		i.aggregates.observe(userID, metricName(metric), t, histogramApproxBytes(&h))
+	}
+
+	return nil
+}
+
+// histogramApproxBytes estimates the wire size of a histogram sample for the
+// aggregate byte counters: a fixed header (schema, zero threshold/count,
+// sum, count) plus 8 bytes per bucket delta. It is an estimate, not the size
+// of the gob-encoded sample the histogram chunk stores.
+func histogramApproxBytes(h *client.Histogram) uint64 {
+	const header = 40
+	return header + uint64(len(h.PositiveDeltas)+len(h.NegativeDeltas))*8
+}
@@ -0,0 +1,33 @@
+package ingester
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/cortexproject/cortex/pkg/util/services"
+)
+
+// BenchmarkIngesterPushHistogram is the histogram-encoding row called out
+// alongside BenchmarkIngesterPush's DoubleDelta/Varbit/Bigchunk cases. It
+// calls appendHistogram directly rather than through Push/TimeSeries.Histogram
+// to isolate the histogram chunk encoding cost from request decoding.
+func BenchmarkIngesterPushHistogram(b *testing.B) {
+	limits := defaultLimitsTestConfig()
+	cfg := defaultIngesterTestConfig()
+	clientCfg := defaultClientTestConfig()
+
+	_, ing := newTestStore(b, cfg, clientCfg, limits, nil)
+	defer services.StopAndAwaitTerminated(context.Background(), ing) //nolint:errcheck
+
+	ctx := user.InjectOrgID(context.Background(), "1")
+	m := labelPairs{{Name: "__name__", Value: "bench_histogram"}}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		require.NoError(b, ing.appendHistogram(ctx, "1", m, int64(i+1), testHistogram(uint64(i+1)), client.API))
+	}
+}
@@ -0,0 +1,251 @@
+package ingester
+
+import (
+	"context"
+	"flag"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+// Config holds the ingester settings this backlog's commits read or write.
+// The production Config carries a great deal more (ring, limits, lifecycler
+// knobs); only the fields this series of changes actually touches are
+// declared here.
+type Config struct {
+	FlushCheckPeriod time.Duration
+	MaxChunkIdle     time.Duration
+	RetainPeriod     time.Duration
+	MaxChunkAge      time.Duration
+	SpreadFlushes    bool
+
+	WALConfig          WALConfig
+	QueueManagerConfig QueueManagerConfig
+	CuratorConfig      CuratorConfig
+	AggregatesConfig   AggregatesConfig
+}
+
+// RegisterFlags registers every embedded sub-config's flags. Config's own
+// fields (FlushCheckPeriod and the rest) get no flags here.
+func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
+	cfg.WALConfig.RegisterFlags(f)
+	cfg.QueueManagerConfig.RegisterFlags(f)
+	cfg.CuratorConfig.RegisterFlags(f)
+	cfg.AggregatesConfig.RegisterFlags(f)
+}
+
+// Ingester is the state this backlog's commits attach subsystems to: WAL
+// recovery, the sharded flush queue, the retention curator, and the
+// pre-aggregated counters all hang off fields here and are wired up in New.
+type Ingester struct {
+	cfg       Config
+	store     chunkStore
+	overrides *validation.Overrides
+
+	userStates *userStates
+
+	wal          *walWrapper
+	checkpointer *checkpointer
+
+	flushQueue *queueManager
+	curator    *curator
+	aggregates *aggregator
+
+	flushQuit chan struct{}
+	flushDone chan struct{}
+}
+
+// New builds an Ingester, replays any WAL/checkpoint left over from a
+// previous run, and starts the background loops this backlog added. It
+// returns only once recovery has completed, so a caller that then starts the
+// lifecycler and joins the ring as ACTIVE is guaranteed to already have any
+// previously-durable data in memory.
+func New(cfg Config, clientCfg interface{}, overrides *validation.Overrides, store chunkStore, reg prometheus.Registerer) (*Ingester, error) {
+	i := &Ingester{
+		cfg:        cfg,
+		store:      store,
+		overrides:  overrides,
+		userStates: newUserStates(),
+		flushQuit:  make(chan struct{}),
+		flushDone:  make(chan struct{}),
+	}
+
+	if err := i.wireRecovery(reg); err != nil {
+		return nil, err
+	}
+
+	i.flushQueue = newQueueManager(cfg.QueueManagerConfig, store, reg)
+
+	i.curator = newCurator(cfg.CuratorConfig, overrides, i.userStates, reg)
+	go i.curator.run()
+
+	i.aggregates = newAggregator(cfg.AggregatesConfig)
+
+	go i.flushLoop()
+
+	return i, nil
+}
+
+// wireRecovery opens the WAL (if enabled), replays the last checkpoint and
+// any WAL segments newer than it into i.userStates, and starts the
+// background checkpoint loop - the integration point New() calls before
+// returning, so recovered series are in memory before the ingester ever
+// accepts a Push or joins the ring.
+func (i *Ingester) wireRecovery(reg prometheus.Registerer) error {
+	wal, err := newWAL(i.cfg.WALConfig, reg)
+	if err != nil {
+		return err
+	}
+	i.wal = wal
+
+	if err := i.recoverFromWAL(); err != nil {
+		return err
+	}
+
+	if wal != nil && i.cfg.WALConfig.CheckpointEnabled {
+		i.checkpointer = newCheckpointer(i.cfg.WALConfig, i, wal)
+		go i.checkpointer.loop()
+	}
+	return nil
+}
+
+// stopRecovery stops the checkpoint loop and the WAL writer, called from the
+// ingester's shutdown path.
+func (i *Ingester) stopRecovery() {
+	if i.checkpointer != nil {
+		i.checkpointer.stop()
+	}
+	if i.wal != nil {
+		i.wal.Stop()
+	}
+}
+
+// shutdown stops the flush queue (draining every shard to the store first)
+// and the WAL/checkpoint loop, in that order so nothing still in the flush
+// queue is lost by a WAL truncation racing its last flush.
+func (i *Ingester) shutdown() {
+	close(i.flushQuit)
+	<-i.flushDone
+	i.flushAll()
+
+	if i.curator != nil {
+		i.curator.stop()
+	}
+	if i.flushQueue != nil {
+		i.flushQueue.stop()
+	}
+	i.stopRecovery()
+}
+
+// flushChunk is the integration point the idle/spread flush sweep calls
+// instead of writing straight to the store: it hands the chunk off to the
+// sharded queue, so a slow store first fills only the queue of the shard
+// the series hashes to. Once that queue is full, enqueue blocks, and with it
+// the sweep that called flushChunk.
+func (i *Ingester) flushChunk(ctx context.Context, userID string, fp model.Fingerprint, chunks []chunk.Chunk) error {
+	return i.flushQueue.enqueue(ctx, flushItem{userID: userID, fp: fp, chunks: chunks})
+}
+
+// flushLoop is the idle/spread flush sweep flushChunk's doc comment refers
+// to: it wakes up every FlushCheckPeriod and flushes whatever's gone idle,
+// so a real Push workload - not just a test calling flushQueue.enqueue
+// directly - drives data into the sharded queue and out to the store.
+func (i *Ingester) flushLoop() {
+	defer close(i.flushDone)
+
+	if i.cfg.FlushCheckPeriod <= 0 {
+		<-i.flushQuit
+		return
+	}
+
+	ticker := time.NewTicker(i.cfg.FlushCheckPeriod)
+	defer ticker.Stop()
+
+	var tick int64
+	for {
+		select {
+		case <-ticker.C:
+			i.sweepFlush(tick)
+			tick++
+		case <-i.flushQuit:
+			return
+		}
+	}
+}
+
+// sweepSpreadBuckets bounds how many ticks SpreadFlushes staggers an idle
+// series' flush across, so a burst of series going idle at the same moment
+// doesn't all flush on the very next tick.
+const sweepSpreadBuckets = 16
+
+// sweepFlush flushes every idle series across every tenant, then evicts
+// whatever's additionally sat flushed-and-idle past RetainPeriod. With
+// SpreadFlushes set, an individual series is only considered on one tick in
+// every sweepSpreadBuckets, picked deterministically from its fingerprint,
+// rather than on every tick - spreading a burst of simultaneously-idle
+// series' flushes out instead of sending them to the store all at once.
+func (i *Ingester) sweepFlush(tick int64) {
+	now := model.Now()
+	maxChunkIdle := model.Time(i.cfg.MaxChunkIdle.Milliseconds())
+	retainPeriod := model.Time(i.cfg.RetainPeriod.Milliseconds())
+
+	for _, userID := range i.userStates.cp() {
+		state, ok := i.userStates.get(userID)
+		if !ok {
+			continue
+		}
+
+		ctx := context.Background()
+		_ = state.forSeriesMatching(ctx, nil, func(fp model.Fingerprint, series *memorySeries) error {
+			if i.cfg.SpreadFlushes && uint64(fp)%sweepSpreadBuckets != uint64(tick)%sweepSpreadBuckets {
+				return nil
+			}
+
+			if series.flushedAt == 0 && now-series.lastTime >= maxChunkIdle {
+				if err := i.flushChunk(ctx, userID, fp, []chunk.Chunk{{UserID: userID, Metric: series.metric}}); err != nil {
+					return nil // enqueue fails only once the queue manager has stopped; the series stays unflushed.
+				}
+				series.flushedAt = now
+			}
+
+			if series.flushedAt != 0 && now-series.flushedAt >= retainPeriod {
+				state.deleteSeries(fp)
+			}
+			return nil
+		}, nil, nil)
+	}
+}
+
+// flushAll flushes every series, regardless of idle time, across every
+// tenant - the integration point shutdown calls so a stopped ingester
+// doesn't lose whatever hasn't gone idle yet.
+func (i *Ingester) flushAll() {
+	ctx := context.Background()
+	for _, userID := range i.userStates.cp() {
+		state, ok := i.userStates.get(userID)
+		if !ok {
+			continue
+		}
+		_ = state.forSeriesMatching(ctx, nil, func(fp model.Fingerprint, series *memorySeries) error {
+			if series.flushedAt != 0 {
+				return nil
+			}
+			_ = i.flushChunk(ctx, userID, fp, []chunk.Chunk{{UserID: userID, Metric: series.metric}})
+			return nil
+		}, nil, nil)
+	}
+}
+
+// syntheticSeries is the integration point QueryStream calls to fold the
+// pre-aggregated bytes/samples counters into its regular response, after it
+// has already streamed every matching real series for [from, through].
+func (i *Ingester) syntheticSeries(userID string, from, through model.Time) []*model.SampleStream {
+	if i.aggregates == nil {
+		return nil
+	}
+	return i.aggregates.querySynthetic(userID, from, through)
+}
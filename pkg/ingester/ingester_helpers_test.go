@@ -0,0 +1,66 @@
+package ingester
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+// userID is the tenant every single-user test in this package pushes
+// samples as, unless a test needs to compare behaviour across several
+// tenants (those build their own "1", "2", "3" list instead).
+const userID = "1"
+
+// defaultIngesterTestConfig returns a Config with every background loop's
+// period flag-defaulted, then set long enough (or disabled) that it never
+// fires during a test unless that test explicitly shortens it - mirroring
+// how TestIngesterIdleFlush/TestIngesterSpreadFlush override FlushCheckPeriod
+// and friends themselves.
+func defaultIngesterTestConfig() Config {
+	cfg := Config{}
+	cfg.RegisterFlags(flag.NewFlagSet("", flag.PanicOnError))
+
+	cfg.FlushCheckPeriod = 99999 * time.Hour
+	cfg.MaxChunkIdle = 99999 * time.Hour
+	cfg.RetainPeriod = 99999 * time.Hour
+	cfg.MaxChunkAge = 24 * time.Hour
+	return cfg
+}
+
+// defaultClientTestConfig returns a flag-defaulted client.Config.
+func defaultClientTestConfig() client.Config {
+	cfg := client.Config{}
+	cfg.RegisterFlags(flag.NewFlagSet("", flag.PanicOnError))
+	return cfg
+}
+
+// defaultLimitsTestConfig returns the zero-value validation.Limits: every
+// limit defaults to "unlimited", so a test that cares about one opts in by
+// setting that field itself (as TestIngesterUserSeriesLimitExceeded and
+// TestIngesterMetricSeriesLimitExceeded both do).
+func defaultLimitsTestConfig() validation.Limits {
+	return validation.Limits{}
+}
+
+// removeEmptyDir removes dir if it exists and is empty, treating a missing
+// dir as success (there's nothing left to clean up) and a non-empty one as
+// an error (the caller almost certainly didn't mean to leave data behind).
+func removeEmptyDir(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return errors.Errorf("directory %s is not empty", dir)
+	}
+	return os.Remove(dir)
+}
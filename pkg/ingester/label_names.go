@@ -0,0 +1,58 @@
+package ingester
+
+import (
+	"context"
+	"sort"
+
+	"github.com/prometheus/common/model"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+)
+
+// LabelNames returns the distinct label names present on series matching
+// req's matchers and time range, rather than the union of all label names
+// known for the tenant. It applies the matchers through the same
+// forSeriesMatching path as Query. There is no postings index: every series
+// of the tenant is visited and tested against the matchers, however
+// selective they are.
+func (i *Ingester) LabelNames(ctx context.Context, req *client.LabelNamesRequest) (*client.LabelNamesResponse, error) {
+	userID, err := user.ExtractOrgID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	state, ok := i.userStates.get(userID)
+	if !ok {
+		return &client.LabelNamesResponse{}, nil
+	}
+
+	matchers, err := client.FromLabelMatchers(req.Matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	from, through := model.Time(req.StartTimestampMs), model.Time(req.EndTimestampMs)
+
+	names := map[string]struct{}{}
+	err = state.forSeriesMatching(ctx, matchers, func(_ model.Fingerprint, series *memorySeries) error {
+		if !series.overlapsRange(from, through) {
+			return nil
+		}
+		for _, l := range series.metric {
+			names[l.Name] = struct{}{}
+		}
+		return nil
+	}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(names))
+	for name := range names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+
+	return &client.LabelNamesResponse{LabelNames: result}, nil
+}
@@ -0,0 +1,48 @@
+package ingester
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+)
+
+func TestIngesterLabelNamesMatcherPushdown(t *testing.T) {
+	_, ing := newDefaultTestStore(t)
+	userIDs, testData := pushTestSamples(t, ing, 10, 10, 0)
+
+	for _, userID := range userIDs {
+		ctx := user.InjectOrgID(context.Background(), userID)
+
+		matcher, err := labels.NewMatcher(labels.MatchEqual, model.MetricNameLabel, "testmetric_1")
+		require.NoError(t, err)
+		req, err := client.ToLabelNamesRequest(model.Earliest, model.Latest, []*labels.Matcher{matcher})
+		require.NoError(t, err)
+
+		resp, err := ing.LabelNames(ctx, req)
+		require.NoError(t, err)
+
+		var want *model.SampleStream
+		for _, ss := range testData[userID] {
+			if ss.Metric[model.MetricNameLabel] == "testmetric_1" {
+				want = ss
+				break
+			}
+		}
+		require.NotNil(t, want, "expected a testmetric_1 series in the pushed test data")
+
+		expected := map[string]bool{}
+		for name := range want.Metric {
+			expected[string(name)] = true
+		}
+		require.Equal(t, len(expected), len(resp.LabelNames))
+		for _, name := range resp.LabelNames {
+			require.True(t, expected[name], "unexpected label name %q for a single-series matcher", name)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package ingester
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/prometheus/common/model"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+)
+
+// Query returns every float sample of every series matching req's matchers
+// and time range, decoded in full - the non-streaming counterpart to
+// QueryStream, used by callers (like this package's own tests) that want a
+// plain model.Matrix rather than wire-encoded chunks.
+func (i *Ingester) Query(ctx context.Context, req *client.QueryRequest) (*client.QueryResponse, error) {
+	userID, err := user.ExtractOrgID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	matchers, err := client.FromLabelMatchers(req.Matchers)
+	if err != nil {
+		return nil, err
+	}
+	from, through := model.Time(req.StartTimestampMs), model.Time(req.EndTimestampMs)
+
+	resp := &client.QueryResponse{}
+	state, ok := i.userStates.get(userID)
+	if !ok {
+		return resp, nil
+	}
+
+	err = state.forSeriesMatching(ctx, matchers, func(_ model.Fingerprint, series *memorySeries) error {
+		samples := series.samplesInRange(from, through)
+		if len(samples) == 0 {
+			return nil
+		}
+		ts := &client.TimeSeries{
+			Labels:  client.FromLabelsToLabelAdapters(series.metric),
+			Samples: make([]client.Sample, 0, len(samples)),
+		}
+		for _, s := range samples {
+			ts.Samples = append(ts.Samples, client.Sample{TimestampMs: int64(s.Timestamp), Value: float64(s.Value)})
+		}
+		resp.Timeseries = append(resp.Timeseries, ts)
+		return nil
+	}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// QueryStreamServer is the subset of the gRPC server-streaming interface
+// QueryStream needs: enough for both the real gRPC-generated stream and a
+// test double (see ingester_test.go's stream type) to satisfy it.
+type QueryStreamServer interface {
+	Context() context.Context
+	Send(*client.QueryStreamResponse) error
+}
+
+// QueryStream is the streaming counterpart to Query: it sends matching
+// series as encoded chunks rather than decoded samples, and - unlike Query -
+// also folds in the tenant's pre-aggregated synthetic series once every real
+// series has been streamed, so a single QueryStream call returns both kinds
+// from one RPC.
+func (i *Ingester) QueryStream(req *client.QueryRequest, stream QueryStreamServer) error {
+	userID, err := user.ExtractOrgID(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	matchers, err := client.FromLabelMatchers(req.Matchers)
+	if err != nil {
+		return err
+	}
+	from, through := model.Time(req.StartTimestampMs), model.Time(req.EndTimestampMs)
+
+	resp := &client.QueryStreamResponse{}
+	state, ok := i.userStates.get(userID)
+	if ok {
+		err = state.forSeriesMatching(stream.Context(), matchers, func(_ model.Fingerprint, series *memorySeries) error {
+			if !series.overlapsRange(from, through) {
+				return nil
+			}
+
+			tsc := &client.TimeSeriesChunk{
+				UserID: userID,
+				Labels: client.FromLabelsToLabelAdapters(series.metric),
+			}
+
+			if samples := series.samplesInRange(from, through); len(samples) > 0 {
+				tsc.Chunks = []client.Chunk{{Encoding: client.GobFloatEncoding, Data: encodeGob(samples)}}
+			}
+			if series.histogramHead != nil {
+				var buf bytes.Buffer
+				if err := series.histogramHead.Marshal(&buf); err != nil {
+					return err
+				}
+				tsc.HistogramChunks = []client.Chunk{{Encoding: client.GobHistogramEncoding, Data: buf.Bytes()}}
+			}
+
+			if len(tsc.Chunks) == 0 && len(tsc.HistogramChunks) == 0 {
+				return nil
+			}
+			resp.Timeseries = append(resp.Timeseries, tsc)
+			return nil
+		}, nil, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, ss := range i.syntheticSeries(userID, from, through) {
+		if len(ss.Values) == 0 {
+			continue
+		}
+		resp.Timeseries = append(resp.Timeseries, &client.TimeSeriesChunk{
+			UserID: userID,
+			Labels: client.FromMetricsToLabelAdapters(ss.Metric),
+			Chunks: []client.Chunk{{Encoding: client.GobFloatEncoding, Data: encodeGob(ss.Values)}},
+		})
+	}
+
+	if len(resp.Timeseries) == 0 {
+		return nil
+	}
+	return stream.Send(resp)
+}
@@ -0,0 +1,231 @@
+package ingester
+
+import (
+	"context"
+	"flag"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+
+	"github.com/cortexproject/cortex/pkg/chunk"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+// QueueManagerConfig configures the sharded flush queue that replaces the
+// single serial flush loop, modeled on Prometheus' remote_write queue
+// manager.
+type QueueManagerConfig struct {
+	MinShards         int           `yaml:"min_shards"`
+	MaxShards         int           `yaml:"max_shards"`
+	MaxSamplesPerSend int           `yaml:"max_samples_per_send"`
+	BatchSendDeadline time.Duration `yaml:"batch_send_deadline"`
+	MinBackoff        time.Duration `yaml:"min_backoff"`
+	MaxBackoff        time.Duration `yaml:"max_backoff"`
+	Capacity          int           `yaml:"capacity"` // per-shard channel depth.
+}
+
+// RegisterFlags registers flags for QueueManagerConfig with sensible
+// defaults for a single ingester's flush workload.
+func (cfg *QueueManagerConfig) RegisterFlags(f *flag.FlagSet) {
+	f.IntVar(&cfg.MinShards, "ingester.flush-queue-min-shards", 1, "Minimum number of flush queue shards.")
+	f.IntVar(&cfg.MaxShards, "ingester.flush-queue-max-shards", 50, "Maximum number of flush queue shards.")
+	f.IntVar(&cfg.MaxSamplesPerSend, "ingester.flush-queue-max-samples-per-send", 500, "Maximum number of chunks to flush in a single store.Put call.")
+	f.DurationVar(&cfg.BatchSendDeadline, "ingester.flush-queue-batch-send-deadline", 5*time.Second, "Maximum time a batch can sit in a shard queue before being sent regardless of size.")
+	f.DurationVar(&cfg.MinBackoff, "ingester.flush-queue-min-backoff", 30*time.Millisecond, "Minimum backoff after a failed store.Put.")
+	f.DurationVar(&cfg.MaxBackoff, "ingester.flush-queue-max-backoff", 5*time.Second, "Maximum backoff after a failed store.Put.")
+	f.IntVar(&cfg.Capacity, "ingester.flush-queue-capacity", 2500, "Per-shard queue depth before Push backpressures the caller.")
+}
+
+// flushItem is one chunk queued for flushing, tagged with the fingerprint it
+// came from so shards can be chosen deterministically per series.
+type flushItem struct {
+	userID string
+	fp     model.Fingerprint
+	chunks []chunk.Chunk
+}
+
+// queueManager replaces the ingester's single flush loop with N shards keyed
+// by series fingerprint, each batching sends and backing off independently,
+// and grows or shrinks shard count based on observed enqueue/dequeue rates -
+// the same dynamic-sharding strategy Prometheus' remote write queue uses to
+// keep up with a bursty producer without OOMing.
+type queueManager struct {
+	cfg   QueueManagerConfig
+	store chunkStore
+
+	mtx    sync.RWMutex
+	shards []*flushShard
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+
+	metrics *queueManagerMetrics
+}
+
+type chunkStore interface {
+	Put(ctx context.Context, chunks []chunk.Chunk) error
+}
+
+type queueManagerMetrics struct {
+	shardsDesired  prometheus.Gauge
+	samplesIn      *prometheus.CounterVec
+	samplesOut     *prometheus.CounterVec
+	samplesDropped *prometheus.CounterVec
+	retries        *prometheus.CounterVec
+	queueDepth     *prometheus.GaugeVec
+}
+
+func newQueueManagerMetrics(reg prometheus.Registerer) *queueManagerMetrics {
+	m := &queueManagerMetrics{
+		shardsDesired: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "cortex_ingester_flush_queue_shards",
+			Help: "Current number of flush queue shards.",
+		}),
+		samplesIn: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_ingester_flush_queue_samples_in_total",
+			Help: "Chunks enqueued for flushing, per shard.",
+		}, []string{"shard"}),
+		samplesOut: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_ingester_flush_queue_samples_out_total",
+			Help: "Chunks successfully flushed to the store, per shard.",
+		}, []string{"shard"}),
+		samplesDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_ingester_flush_queue_samples_dropped_total",
+			Help: "Chunks dropped because their shard stopped while store.Put was still failing, per shard.",
+		}, []string{"shard"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cortex_ingester_flush_queue_retries_total",
+			Help: "store.Put retries, per shard.",
+		}, []string{"shard"}),
+		queueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "cortex_ingester_flush_queue_depth",
+			Help: "Current number of items queued, per shard.",
+		}, []string{"shard"}),
+	}
+	if reg != nil {
+		reg.MustRegister(m.shardsDesired, m.samplesIn, m.samplesOut, m.samplesDropped, m.retries, m.queueDepth)
+	}
+	return m
+}
+
+// newQueueManager starts a queue manager with cfg.MinShards shards.
+func newQueueManager(cfg QueueManagerConfig, store chunkStore, reg prometheus.Registerer) *queueManager {
+	qm := &queueManager{
+		cfg:     cfg,
+		store:   store,
+		quit:    make(chan struct{}),
+		metrics: newQueueManagerMetrics(reg),
+	}
+	qm.setShards(cfg.MinShards)
+
+	qm.wg.Add(1)
+	go qm.reshardLoop()
+	return qm
+}
+
+// enqueue routes item to the shard owned by its fingerprint, blocking the
+// caller (i.e. backpressuring Push) if that shard's channel is full.
+func (qm *queueManager) enqueue(ctx context.Context, item flushItem) error {
+	qm.mtx.RLock()
+	shard := qm.shards[uint64(item.fp)%uint64(len(qm.shards))]
+	qm.mtx.RUnlock()
+
+	select {
+	case shard.queue <- item:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-qm.quit:
+		return errQueueManagerStopped
+	}
+}
+
+// reshardLoop recomputes the desired shard count every ten seconds from the
+// ratio of observed in-rate to out-rate across all shards, clamped to
+// [MinShards, MaxShards], mirroring Prometheus remote write's
+// calculateDesiredShards.
+func (qm *queueManager) reshardLoop() {
+	defer qm.wg.Done()
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			qm.reshard()
+		case <-qm.quit:
+			return
+		}
+	}
+}
+
+func (qm *queueManager) reshard() {
+	qm.mtx.Lock()
+	defer qm.mtx.Unlock()
+
+	var in, out float64
+	for _, s := range qm.shards {
+		in += s.inRate()
+		out += s.outRate()
+	}
+
+	desired := len(qm.shards)
+	switch {
+	case out > 0:
+		desired = int(in / out * float64(len(qm.shards)))
+	case in > 0:
+		// Nothing has drained yet but items are arriving: scale up
+		// aggressively rather than waiting for a first out-rate sample.
+		desired = len(qm.shards) * 2
+	}
+	if desired < qm.cfg.MinShards {
+		desired = qm.cfg.MinShards
+	}
+	if desired > qm.cfg.MaxShards {
+		desired = qm.cfg.MaxShards
+	}
+	if desired == len(qm.shards) {
+		return
+	}
+
+	level.Info(util.Logger).Log("msg", "resharding flush queue", "from", len(qm.shards), "to", desired)
+	qm.setShardsLocked(desired)
+	qm.metrics.shardsDesired.Set(float64(desired))
+}
+
+func (qm *queueManager) setShards(n int) {
+	qm.mtx.Lock()
+	defer qm.mtx.Unlock()
+	qm.setShardsLocked(n)
+}
+
+func (qm *queueManager) setShardsLocked(n int) {
+	old := qm.shards
+	qm.shards = make([]*flushShard, n)
+	for i := range qm.shards {
+		qm.shards[i] = newFlushShard(i, qm.cfg, qm.store, qm.metrics)
+	}
+	for _, s := range old {
+		s.stop()
+	}
+}
+
+// stop drains and stops every shard.
+func (qm *queueManager) stop() {
+	close(qm.quit)
+	qm.wg.Wait()
+	qm.mtx.RLock()
+	defer qm.mtx.RUnlock()
+	for _, s := range qm.shards {
+		s.stop()
+	}
+}
+
+var errQueueManagerStopped = &queueManagerStoppedErr{}
+
+type queueManagerStoppedErr struct{}
+
+func (*queueManagerStoppedErr) Error() string { return "flush queue manager stopped" }
@@ -0,0 +1,145 @@
+package ingester
+
+import (
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+
+	"github.com/cortexproject/cortex/pkg/chunk/encoding"
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+)
+
+// labelPairs is the wire-shaped label list Push/append thread through before
+// a series is resolved to its memorySeries: the same (Name, Value) shape as
+// client.LabelAdapter, just named for what this package uses it for.
+type labelPairs []client.LabelAdapter
+
+// memorySeries is one tenant's in-memory series: its labels, an append-only
+// float head chunk, and (if it has ever received a native histogram sample)
+// a histogram head chunk. The idle-flush sweep treats the series as one
+// unit: a single flushedAt covers it, and once it has sat flushed past
+// RetainPeriod the whole series, both heads together, is dropped.
+type memorySeries struct {
+	metric labels.Labels
+
+	lastTime        model.Time
+	lastSampleValue model.SampleValue
+
+	samples []model.SamplePair
+
+	histogramHead *encoding.HistogramChunk
+
+	// flushedAt is the last time this series' float head was handed to
+	// flushChunk, zero if it never has been. The idle-flush sweep uses it
+	// both to avoid re-flushing a series every sweep while it stays idle,
+	// and to decide when a since-flushed, still-idle series is old enough
+	// to drop from memory entirely.
+	flushedAt model.Time
+}
+
+func newMemorySeries(metric labels.Labels) *memorySeries {
+	return &memorySeries{metric: metric}
+}
+
+// checkNewSample rejects a sample strictly older than the series' last float
+// sample; a series with no float samples accepts any timestamp here. It
+// deliberately does not look at the incoming value - the
+// repeated-timestamp-but-different-value case is rejected by add() instead,
+// since only add() also needs to treat a genuine duplicate (same timestamp
+// *and* value) as a silent no-op rather than an error.
+func (s *memorySeries) checkNewSample(t model.Time) error {
+	if len(s.samples) == 0 {
+		return nil
+	}
+	if t < s.lastTime {
+		return newValidationError(400, "sample timestamp out of order; last timestamp: %s, incoming timestamp: %s for series %s", s.lastTime, t, s.metric)
+	}
+	return nil
+}
+
+// add appends (t, v) to the float head, unless it exactly repeats the last
+// sample (a no-op) or shares the last sample's timestamp with a different
+// value (an error) - checkNewSample has already rejected anything strictly
+// older than lastTime by the time add is called.
+func (s *memorySeries) add(t model.Time, v model.SampleValue) error {
+	if len(s.samples) > 0 && t == s.lastTime {
+		if v == s.lastSampleValue {
+			return nil
+		}
+		return newValidationError(400, "sample with repeated timestamp but different value for series %s", s.metric)
+	}
+	s.samples = append(s.samples, model.SamplePair{Timestamp: t, Value: v})
+	return nil
+}
+
+// head returns the float head chunk, or nil if this series has never
+// received a float sample.
+func (s *memorySeries) head() *memorySeries {
+	if len(s.samples) == 0 {
+		return nil
+	}
+	return s
+}
+
+// marshalForCheckpoint encodes the float head's samples for
+// writeCheckpointSeries, using the same gob framing as the rest of this
+// package's checkpoint/WAL records rather than a dedicated chunk encoding.
+func (s *memorySeries) marshalForCheckpoint() []byte {
+	return encodeGob(s.samples)
+}
+
+// unmarshalHeadFromCheckpoint is the inverse of marshalForCheckpoint.
+func unmarshalHeadFromCheckpoint(b []byte) ([]model.SamplePair, error) {
+	var samples []model.SamplePair
+	if err := decodeGob(b, &samples); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// overlapsRange reports whether this series has any float or histogram
+// sample in [from, through].
+func (s *memorySeries) overlapsRange(from, through model.Time) bool {
+	for _, sp := range s.samples {
+		if sp.Timestamp >= from && sp.Timestamp <= through {
+			return true
+		}
+	}
+	if s.histogramHead != nil {
+		times, _, err := s.histogramHead.Samples()
+		if err == nil {
+			for _, t := range times {
+				if t >= from && t <= through {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// samplesInRange returns the float samples in [from, through], in order.
+func (s *memorySeries) samplesInRange(from, through model.Time) []model.SamplePair {
+	var out []model.SamplePair
+	for _, sp := range s.samples {
+		if sp.Timestamp >= from && sp.Timestamp <= through {
+			out = append(out, sp)
+		}
+	}
+	return out
+}
+
+// dropSamplesBefore removes every float sample strictly older than cutoff,
+// returning how many were dropped. Used by the curator to enforce retention.
+func (s *memorySeries) dropSamplesBefore(cutoff model.Time) int {
+	kept := s.samples[:0]
+	dropped := 0
+	for _, sp := range s.samples {
+		if sp.Timestamp < cutoff {
+			dropped++
+			continue
+		}
+		kept = append(kept, sp)
+	}
+	s.samples = kept
+	return dropped
+}
@@ -0,0 +1,234 @@
+package ingester
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+// userStates holds every tenant's in-memory series state, keyed by user ID.
+type userStates struct {
+	mtx    sync.RWMutex
+	states map[string]*userState
+}
+
+func newUserStates() *userStates {
+	return &userStates{states: map[string]*userState{}}
+}
+
+func (us *userStates) get(userID string) (*userState, bool) {
+	us.mtx.RLock()
+	defer us.mtx.RUnlock()
+	state, ok := us.states[userID]
+	return state, ok
+}
+
+// getOrCreate returns the userState for userID, creating an empty one on
+// first use. Limits are not stored on the state: getOrCreateSeries takes the
+// Overrides on each call, so this never needs to know about limits itself.
+func (us *userStates) getOrCreate(userID string) (*userState, error) {
+	us.mtx.Lock()
+	defer us.mtx.Unlock()
+	state, ok := us.states[userID]
+	if !ok {
+		state = newUserState(userID)
+		us.states[userID] = state
+	}
+	return state, nil
+}
+
+// cp returns a snapshot of the currently known user IDs, safe to range over
+// without holding us.mtx - the checkpointer and curator both walk this list
+// one user at a time rather than holding a single lock across every user.
+func (us *userStates) cp() []string {
+	us.mtx.RLock()
+	defer us.mtx.RUnlock()
+	ids := make([]string, 0, len(us.states))
+	for id := range us.states {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// userState is a single tenant's series index: every known fingerprint's
+// memorySeries, guarded by a striped fingerprintLocker rather than one mutex
+// per tenant, so unrelated series can be appended to concurrently.
+type userState struct {
+	userID   string
+	fpLocker *fingerprintLocker
+
+	mtx        sync.RWMutex
+	fpToSeries map[model.Fingerprint]*memorySeries
+
+	seriesMtx       sync.Mutex
+	seriesPerMetric map[string]int
+}
+
+func newUserState(userID string) *userState {
+	return &userState{
+		userID:          userID,
+		fpLocker:        newFingerprintLocker(16),
+		fpToSeries:      map[model.Fingerprint]*memorySeries{},
+		seriesPerMetric: map[string]int{},
+	}
+}
+
+// getOrCreateSeries resolves metric to its fingerprint, creating and
+// registering a new memorySeries the first time this fingerprint is seen for
+// this tenant, enforcing overrides' per-user and per-metric series limits on
+// creation. The returned series' fingerprint is already locked via
+// u.fpLocker; the caller is responsible for unlocking it.
+func (u *userState) getOrCreateSeries(ctx context.Context, userID string, metric labelPairs, overrides *validation.Overrides) (model.Fingerprint, *memorySeries, error) {
+	lbls := labelPairsToLabels(metric)
+	fp := model.Fingerprint(labels.Labels(lbls).Hash())
+
+	u.fpLocker.Lock(fp)
+
+	u.mtx.RLock()
+	series, ok := u.fpToSeries[fp]
+	u.mtx.RUnlock()
+	if ok {
+		return fp, series, nil
+	}
+
+	metricName := metricName(metric)
+
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	if series, ok := u.fpToSeries[fp]; ok {
+		return fp, series, nil
+	}
+
+	if overrides != nil {
+		u.seriesMtx.Lock()
+		total := len(u.fpToSeries)
+		perMetric := u.seriesPerMetric[metricName]
+		u.seriesMtx.Unlock()
+
+		if max := overrides.MaxLocalSeriesPerUser(userID); max > 0 && total >= max {
+			u.fpLocker.Unlock(fp)
+			return 0, nil, newSeriesLimitError(userID, "per-user series limit")
+		}
+		if max := overrides.MaxLocalSeriesPerMetric(userID); max > 0 && perMetric >= max {
+			u.fpLocker.Unlock(fp)
+			return 0, nil, newSeriesLimitError(userID, "per-metric series limit")
+		}
+	}
+
+	series = newMemorySeries(lbls)
+	u.fpToSeries[fp] = series
+
+	u.seriesMtx.Lock()
+	u.seriesPerMetric[metricName]++
+	u.seriesMtx.Unlock()
+
+	return fp, series, nil
+}
+
+// forSeriesMatching iterates every series belonging to this tenant whose
+// labels satisfy every matcher, invoking fn with fp's lock held, in
+// ascending fingerprint order - curateUser's resume-from-last-fingerprint
+// logic depends on that ordering being deterministic, not just incidentally
+// stable. filter (if non-nil) can reject a series by labels alone before fn
+// ever runs, and onComplete (if non-nil) runs once after every matching
+// series has been visited - neither is used by every caller, but both are
+// threaded through rather than giving forSeriesMatching per-caller variants.
+func (u *userState) forSeriesMatching(
+	ctx context.Context,
+	matchers []*labels.Matcher,
+	fn func(model.Fingerprint, *memorySeries) error,
+	filter func(labels.Labels) bool,
+	onComplete func() error,
+) error {
+	u.mtx.RLock()
+	fps := make([]model.Fingerprint, 0, len(u.fpToSeries))
+	for fp := range u.fpToSeries {
+		fps = append(fps, fp)
+	}
+	u.mtx.RUnlock()
+
+	sort.Slice(fps, func(a, b int) bool { return fps[a] < fps[b] })
+
+	for _, fp := range fps {
+		u.fpLocker.Lock(fp)
+		series, ok := u.fpToSeries[fp]
+		if !ok {
+			u.fpLocker.Unlock(fp)
+			continue
+		}
+
+		if !matchesAll(series.metric, matchers) || (filter != nil && !filter(series.metric)) {
+			u.fpLocker.Unlock(fp)
+			continue
+		}
+
+		err := fn(fp, series)
+		u.fpLocker.Unlock(fp)
+		if err != nil {
+			return err
+		}
+	}
+
+	if onComplete != nil {
+		return onComplete()
+	}
+	return nil
+}
+
+// deleteSeries removes fp from this tenant's index entirely, used once a
+// flushed series has sat idle past RetainPeriod and has nothing left worth
+// keeping in memory. The caller must hold fp's lock.
+func (u *userState) deleteSeries(fp model.Fingerprint) {
+	u.mtx.Lock()
+	delete(u.fpToSeries, fp)
+	u.mtx.Unlock()
+}
+
+func matchesAll(metric labels.Labels, matchers []*labels.Matcher) bool {
+	for _, m := range matchers {
+		if !m.Matches(metric.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+func newSeriesLimitError(userID, what string) error {
+	return newValidationError(429, "per-user series limit exceeded for user %s: %s", userID, what)
+}
+
+// fingerprintLocker stripes locks across a fixed number of mutexes keyed by
+// fingerprint, so concurrent appends to different series rarely contend,
+// while still giving callers a LockAll/UnlockAll escape hatch for operations
+// that must see every series at once.
+type fingerprintLocker struct {
+	stripes []sync.Mutex
+}
+
+func newFingerprintLocker(n int) *fingerprintLocker {
+	return &fingerprintLocker{stripes: make([]sync.Mutex, n)}
+}
+
+func (l *fingerprintLocker) stripeFor(fp model.Fingerprint) *sync.Mutex {
+	return &l.stripes[uint64(fp)%uint64(len(l.stripes))]
+}
+
+func (l *fingerprintLocker) Lock(fp model.Fingerprint)   { l.stripeFor(fp).Lock() }
+func (l *fingerprintLocker) Unlock(fp model.Fingerprint) { l.stripeFor(fp).Unlock() }
+
+func (l *fingerprintLocker) LockAll() {
+	for i := range l.stripes {
+		l.stripes[i].Lock()
+	}
+}
+
+func (l *fingerprintLocker) UnlockAll() {
+	for i := range l.stripes {
+		l.stripes[i].Unlock()
+	}
+}
@@ -0,0 +1,108 @@
+package chunkcompat
+
+import (
+	"bytes"
+	"encoding/gob"
+	"sort"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/cortexproject/cortex/pkg/chunk/encoding"
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+)
+
+// StreamsToMatrix decodes every TimeSeriesChunk across a QueryStream call's
+// responses into a model.Matrix, restricted to [from, through]. A series
+// that carries both float and histogram chunks (there is no such series in
+// this package today, but nothing stops one existing) has both decoded and
+// merged into a single, timestamp-sorted SampleStream.
+func StreamsToMatrix(from, through model.Time, responses []*client.QueryStreamResponse) (model.Matrix, error) {
+	m := model.Matrix{}
+	for _, resp := range responses {
+		for _, tsc := range resp.Timeseries {
+			values, err := decodeFloatChunks(tsc, from, through)
+			if err != nil {
+				return nil, err
+			}
+
+			histValues, err := decodeHistogramChunks(tsc, from, through)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, histValues...)
+			sort.Sort(samplePairsByTimestamp(values))
+
+			if len(values) == 0 {
+				continue
+			}
+			m = append(m, &model.SampleStream{
+				Metric: client.FromLabelAdaptersToMetric(tsc.Labels),
+				Values: values,
+			})
+		}
+	}
+	sort.Sort(m)
+	return m, nil
+}
+
+type samplePairsByTimestamp []model.SamplePair
+
+func (s samplePairsByTimestamp) Len() int           { return len(s) }
+func (s samplePairsByTimestamp) Less(i, j int) bool { return s[i].Timestamp < s[j].Timestamp }
+func (s samplePairsByTimestamp) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// decodeFloatChunks decodes every client.GobFloatEncoding chunk attached to
+// series, the float counterpart to decodeHistogramChunks.
+func decodeFloatChunks(series *client.TimeSeriesChunk, from, through model.Time) ([]model.SamplePair, error) {
+	var samples []model.SamplePair
+	for _, c := range series.Chunks {
+		var points []model.SamplePair
+		if err := gob.NewDecoder(bytes.NewReader(c.Data)).Decode(&points); err != nil {
+			return nil, err
+		}
+		for _, p := range points {
+			if p.Timestamp < from || p.Timestamp > through {
+				continue
+			}
+			samples = append(samples, p)
+		}
+	}
+	return samples, nil
+}
+
+// decodeHistogramChunks extends StreamsToMatrix to decode the histogram
+// chunks attached to a QueryStreamResponse entry. Native histograms aren't
+// representable as a model.SampleValue, so a decoded histogram sample is
+// reported at its observation count rather than dropped.
+func decodeHistogramChunks(series *client.TimeSeriesChunk, from, through model.Time) ([]model.SamplePair, error) {
+	var samples []model.SamplePair
+	for _, hc := range series.HistogramChunks {
+		points, err := decodeHistogramChunk(hc, from, through)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, points...)
+	}
+	return samples, nil
+}
+
+// decodeHistogramChunk decodes a single histogram chunk's samples into
+// (timestamp, count) pairs within [from, through].
+func decodeHistogramChunk(hc client.Chunk, from, through model.Time) ([]model.SamplePair, error) {
+	times, hists, err := encoding.DecodeHistogramChunk(hc.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []model.SamplePair
+	for i, t := range times {
+		if t < from || t > through {
+			continue
+		}
+		out = append(out, model.SamplePair{
+			Timestamp: t,
+			Value:     model.SampleValue(hists[i].Count),
+		})
+	}
+	return out, nil
+}